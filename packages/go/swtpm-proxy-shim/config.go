@@ -0,0 +1,57 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SwtpmProxyInstance describes one vTPM proxy instance within a multi-tenant
+// configuration file. It extends SwtpmProxyOptions with the metadata needed
+// to identify and manage the instance independently of the others.
+type SwtpmProxyInstance struct {
+	// Name uniquely identifies the instance for logging and for matching it
+	// up with its previous configuration across a reload.
+	Name string `yaml:"name"`
+
+	SwtpmProxyOptions `yaml:",inline"`
+}
+
+// Config is the top-level structure of the --config file accepted by the
+// multi-tenant proxy mode: a list of independently-run vTPM instances.
+type Config struct {
+	Instances []SwtpmProxyInstance `yaml:"instances"`
+}
+
+// LoadConfig reads and validates a multi-instance proxy configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if len(cfg.Instances) == 0 {
+		return nil, fmt.Errorf("config file %s defines no instances", path)
+	}
+
+	seen := make(map[string]bool, len(cfg.Instances))
+	for _, inst := range cfg.Instances {
+		if inst.Name == "" {
+			return nil, fmt.Errorf("instance with control socket %q has no name", inst.ControlSocketPath)
+		}
+		if seen[inst.Name] {
+			return nil, fmt.Errorf("duplicate instance name %q", inst.Name)
+		}
+		seen[inst.Name] = true
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,313 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// errCommandDenied marks a ReadFrame failure that occurred after the frame
+// was fully read off the wire (an otherwise well-formed command rejected by
+// the allow-list). Callers may recover from it and keep reading the stream.
+// Every other ReadFrame/ReadResponse error can leave unread frame bytes on
+// the wire and desyncs the stream; callers must treat those as fatal.
+var errCommandDenied = errors.New("command denied by policy")
+
+// ControlCommand identifies a swtpm control channel command.
+type ControlCommand uint32
+
+// swtpm control protocol commands. Values follow the layout of swtpm's
+// swtpm_ioctl.h command enum; CMD_SET_DATAFD is the one command this proxy
+// previously understood.
+const (
+	CmdGetCapability       ControlCommand = 1
+	CmdInit                ControlCommand = 2
+	CmdShutdown            ControlCommand = 3
+	CmdGetTpmEstablished   ControlCommand = 4
+	CmdSetLocality         ControlCommand = 5
+	CmdHashStart           ControlCommand = 6
+	CmdHashData            ControlCommand = 7
+	CmdHashEnd             ControlCommand = 8
+	CmdCancelTpmCmd        ControlCommand = 9
+	CmdStoreVolatile       ControlCommand = 10
+	CmdResetTpmEstablished ControlCommand = 11
+	CmdGetStateBlob        ControlCommand = 12
+	CmdSetStateBlob        ControlCommand = 13
+	CmdStop                ControlCommand = 14
+	CmdGetConfig           ControlCommand = 15
+	CmdSetDataFd           ControlCommand = CMD_SET_DATAFD // 0x10
+	CmdSetBufferSize       ControlCommand = 17
+)
+
+// String returns the swtpm name of the command, or a hex fallback for
+// unrecognised values.
+func (c ControlCommand) String() string {
+	if rule, ok := commandRules[c]; ok {
+		return rule.name
+	}
+	return fmt.Sprintf("CMD_UNKNOWN(%#x)", uint32(c))
+}
+
+// commandRule describes the frame-length bounds for one control command, in
+// bytes including the 4-byte command/result-code header. A bound of -1
+// means unbounded.
+type commandRule struct {
+	name string
+
+	minRequestLen int
+	maxRequestLen int
+
+	minResponseLen int
+	maxResponseLen int
+}
+
+var commandRules = map[ControlCommand]commandRule{
+	CmdGetCapability:       {"CMD_GET_CAPABILITY", 12, 12, 4, 12},
+	CmdInit:                {"CMD_INIT", 8, 8, 4, 4},
+	CmdShutdown:            {"CMD_SHUTDOWN", 4, 4, 4, 4},
+	CmdGetTpmEstablished:   {"CMD_GET_TPMESTABLISHED", 4, 4, 8, 8},
+	CmdSetLocality:         {"CMD_SET_LOCALITY", 5, 5, 4, 4},
+	CmdHashStart:           {"CMD_HASH_START", 4, 4, 4, 4},
+	CmdHashData:            {"CMD_HASH_DATA", 8, 4104, 4, 4},
+	CmdHashEnd:             {"CMD_HASH_END", 4, 4, 4, 4},
+	CmdCancelTpmCmd:        {"CMD_CANCEL_TPM_CMD", 4, 4, 4, 4},
+	CmdStoreVolatile:       {"CMD_STORE_VOLATILE", 4, 4, 4, 4},
+	CmdResetTpmEstablished: {"CMD_RESET_TPMESTABLISHED", 4, 4, 4, 4},
+	CmdGetStateBlob:        {"CMD_GET_STATEBLOB", 12, 12, 4, -1},
+	CmdSetStateBlob:        {"CMD_SET_STATEBLOB", 12, -1, 4, 4},
+	CmdStop:                {"CMD_STOP", 4, 4, 4, 4},
+	CmdGetConfig:           {"CMD_GET_CONFIG", 4, 4, 8, 8},
+	CmdSetDataFd:           {"CMD_SET_DATAFD", 4, 4, 4, 4},
+	CmdSetBufferSize:       {"CMD_SET_BUFFERSIZE", 8, 8, 12, 12},
+}
+
+// ControlFrame is a single message exchanged on the swtpm control channel.
+type ControlFrame struct {
+	Command ControlCommand
+	Payload []byte
+}
+
+// AuditRecord is a structured log entry describing one control channel
+// request/response exchange.
+type AuditRecord struct {
+	Timestamp  time.Time      `json:"timestamp"`
+	Instance   string         `json:"instance"`
+	Command    ControlCommand `json:"command"`
+	ResultCode uint32         `json:"result_code"`
+	BytesIn    int            `json:"bytes_in"`
+	BytesOut   int            `json:"bytes_out"`
+}
+
+// ControlCodec parses and validates swtpm control protocol frames, enforces
+// an optional command allow-list, and produces an AuditRecord for every
+// exchange it proxies.
+type ControlCodec struct {
+	// Instance names the vTPM instance this codec belongs to, for audit
+	// records.
+	Instance string
+
+	// AllowedCommands, if non-nil, restricts which commands are forwarded
+	// to the backend; any command not present is rejected. A nil map
+	// allows every known command.
+	AllowedCommands map[ControlCommand]bool
+
+	// OnAudit is called with a structured record after every exchange. If
+	// nil, the record is logged via Logger instead.
+	OnAudit func(AuditRecord)
+
+	// Logger and Metrics default to slog.Default() and a no-op Metrics
+	// respectively when unset.
+	Logger  *slog.Logger
+	Metrics Metrics
+}
+
+func (c *ControlCodec) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+func (c *ControlCodec) metrics() Metrics {
+	if c.Metrics != nil {
+		return c.Metrics
+	}
+	return noopMetrics{}
+}
+
+// maxFrameSize caps the payload accepted for variable-length commands that
+// have no declared upper bound (e.g. CMD_GET_STATEBLOB, CMD_SET_STATEBLOB),
+// so a corrupt or hostile length field can't make readFramedPayload
+// allocate unbounded memory.
+const maxFrameSize = 1 << 20 // 1 MiB
+
+// readFramedPayload reassembles one frame from r: header has already been
+// read (the 4-byte command or result-code word). Fixed-length commands
+// (minLen == maxLen) are completed with a single io.ReadFull of the
+// remaining bytes. Variable-length commands are followed by a 4-byte
+// length field giving the size of the data that follows it; that field is
+// read first, then the declared number of bytes is read with io.ReadFull.
+// Reading to a declared length instead of trusting whatever a single Read
+// returns is what makes this safe over transports that deliver a frame
+// across multiple reads, such as the TCP/TLS backend connections.
+func readFramedPayload(r io.Reader, header []byte, minLen, maxLen int) ([]byte, error) {
+	if minLen == maxLen {
+		payload := make([]byte, maxLen)
+		copy(payload, header)
+		if _, err := io.ReadFull(r, payload[len(header):]); err != nil {
+			return nil, fmt.Errorf("short frame, want %d bytes: %w", maxLen, err)
+		}
+		return payload, nil
+	}
+
+	lenField := make([]byte, 4)
+	if _, err := io.ReadFull(r, lenField); err != nil {
+		return nil, fmt.Errorf("short frame length field: %w", err)
+	}
+	declared := binary.BigEndian.Uint32(lenField)
+
+	limit := maxLen
+	if limit < 0 || limit > maxFrameSize {
+		limit = maxFrameSize
+	}
+	total := len(header) + len(lenField) + int(declared)
+	if total < minLen || total > limit {
+		return nil, fmt.Errorf("declared frame length %d out of range [%d,%d]", total, minLen, limit)
+	}
+
+	payload := make([]byte, total)
+	copy(payload, header)
+	copy(payload[len(header):], lenField)
+	if _, err := io.ReadFull(r, payload[len(header)+len(lenField):]); err != nil {
+		return nil, fmt.Errorf("short frame data, want %d bytes: %w", declared, err)
+	}
+	return payload, nil
+}
+
+// ReadFrame reads one command frame from r (typically the QEMU side of the
+// control channel) and validates its length against the command's rules and
+// the codec's allow-list.
+func (c *ControlCodec) ReadFrame(r io.Reader) (*ControlFrame, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	cmd := ControlCommand(binary.BigEndian.Uint32(header))
+	rule, known := commandRules[cmd]
+	if !known {
+		return nil, fmt.Errorf("unknown control command %#x", uint32(cmd))
+	}
+
+	payload, err := readFramedPayload(r, header, rule.minRequestLen, rule.maxRequestLen)
+	if err != nil {
+		return nil, fmt.Errorf("%s: request %w", rule.name, err)
+	}
+	if c.AllowedCommands != nil && !c.AllowedCommands[cmd] {
+		return nil, fmt.Errorf("%s: %w", rule.name, errCommandDenied)
+	}
+
+	return &ControlFrame{Command: cmd, Payload: payload}, nil
+}
+
+// ReadResponse reads the response to a previously-read request of the given
+// command from r (typically the backend side of the control channel).
+// Responses have no command header of their own: they start with a 4-byte
+// result code, optionally followed by command-specific data.
+func (c *ControlCodec) ReadResponse(r io.Reader, cmd ControlCommand) (*ControlFrame, error) {
+	rule, known := commandRules[cmd]
+	if !known {
+		return nil, fmt.Errorf("unknown control command %#x", uint32(cmd))
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	payload, err := readFramedPayload(r, header, rule.minResponseLen, rule.maxResponseLen)
+	if err != nil {
+		return nil, fmt.Errorf("%s: response %w", rule.name, err)
+	}
+
+	return &ControlFrame{Command: cmd, Payload: payload}, nil
+}
+
+// WriteFrame writes a frame's payload verbatim to w.
+func (c *ControlCodec) WriteFrame(w io.Writer, frame *ControlFrame) (int, error) {
+	return w.Write(frame.Payload)
+}
+
+// resultCode extracts the leading result code from a response payload.
+func resultCode(payload []byte) uint32 {
+	if len(payload) < 4 {
+		return TPM_FAIL
+	}
+	return binary.BigEndian.Uint32(payload[:4])
+}
+
+// audit builds and emits an AuditRecord for one completed exchange.
+func (c *ControlCodec) audit(cmd ControlCommand, result uint32, bytesIn, bytesOut int) {
+	record := AuditRecord{
+		Timestamp:  time.Now(),
+		Instance:   c.Instance,
+		Command:    cmd,
+		ResultCode: result,
+		BytesIn:    bytesIn,
+		BytesOut:   bytesOut,
+	}
+
+	c.metrics().ControlCommand(cmd)
+
+	if c.OnAudit != nil {
+		c.OnAudit(record)
+		return
+	}
+
+	c.logger().Info("control audit",
+		"instance", record.Instance,
+		"command", record.Command.String(),
+		"result_code", record.ResultCode,
+		"bytes_in", record.BytesIn,
+		"bytes_out", record.BytesOut,
+	)
+}
+
+// rejectionResponse synthesises a TPM_FAIL result for a frame that was
+// rejected by ReadFrame (malformed or denied by policy) instead of
+// forwarding it to the backend.
+func rejectionResponse() *ControlFrame {
+	res := make([]byte, 0, 4)
+	res = binary.BigEndian.AppendUint32(res, uint32(TPM_FAIL))
+	return &ControlFrame{Payload: res}
+}
+
+// allowedCommandSet resolves a list of swtpm command names (e.g.
+// "CMD_SET_STATEBLOB") into the set ControlCodec.AllowedCommands expects.
+// A nil/empty names list allows every known command.
+func allowedCommandSet(names []string) (map[ControlCommand]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	byName := make(map[string]ControlCommand, len(commandRules))
+	for cmd, rule := range commandRules {
+		byName[rule.name] = cmd
+	}
+
+	allowed := make(map[ControlCommand]bool, len(names))
+	for _, name := range names {
+		cmd, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown control command %q", name)
+		}
+		allowed[cmd] = true
+	}
+
+	return allowed, nil
+}
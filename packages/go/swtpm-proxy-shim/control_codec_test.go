@@ -0,0 +1,137 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// chunkReader serves Read calls at most chunkSize bytes at a time, so tests
+// can simulate a frame arriving split across several TCP segments instead
+// of in one Read.
+type chunkReader struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunkSize
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func fixedRequestFrame(cmd ControlCommand, extra []byte) []byte {
+	frame := make([]byte, 0, 4+len(extra))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(cmd))
+	return append(frame, extra...)
+}
+
+func variableRequestFrame(cmd ControlCommand, data []byte) []byte {
+	frame := make([]byte, 0, 8+len(data))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(cmd))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	return append(frame, data...)
+}
+
+func TestReadFrameFixedLength(t *testing.T) {
+	frame := fixedRequestFrame(CmdInit, []byte{0, 0, 0, 0})
+
+	for _, chunkSize := range []int{1, 3, len(frame)} {
+		r := &chunkReader{data: append([]byte(nil), frame...), chunkSize: chunkSize}
+		codec := &ControlCodec{}
+
+		got, err := codec.ReadFrame(r)
+		if err != nil {
+			t.Fatalf("chunkSize=%d: ReadFrame: %v", chunkSize, err)
+		}
+		if got.Command != CmdInit {
+			t.Errorf("chunkSize=%d: Command = %v, want CmdInit", chunkSize, got.Command)
+		}
+		if len(got.Payload) != len(frame) {
+			t.Errorf("chunkSize=%d: Payload len = %d, want %d", chunkSize, len(got.Payload), len(frame))
+		}
+	}
+}
+
+func TestReadFrameVariableLengthAcrossReads(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	frame := variableRequestFrame(CmdHashData, data)
+
+	r := &chunkReader{data: frame, chunkSize: 7}
+	codec := &ControlCodec{}
+
+	got, err := codec.ReadFrame(r)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if got.Command != CmdHashData {
+		t.Fatalf("Command = %v, want CmdHashData", got.Command)
+	}
+	if len(got.Payload) != len(frame) {
+		t.Fatalf("Payload len = %d, want %d", len(got.Payload), len(frame))
+	}
+}
+
+func TestReadFrameRejectsOutOfRangeLength(t *testing.T) {
+	// CMD_HASH_DATA's request tops out at 4104 bytes total; declare more
+	// data than that fits.
+	data := make([]byte, 4100)
+	frame := variableRequestFrame(CmdHashData, data)
+
+	codec := &ControlCodec{}
+	if _, err := codec.ReadFrame(&chunkReader{data: frame, chunkSize: len(frame)}); err == nil {
+		t.Fatal("ReadFrame accepted a frame declaring more data than CMD_HASH_DATA allows")
+	}
+}
+
+func TestReadFrameRejectsUnknownCommand(t *testing.T) {
+	frame := fixedRequestFrame(ControlCommand(0xDEAD), nil)
+
+	codec := &ControlCodec{}
+	if _, err := codec.ReadFrame(&chunkReader{data: frame, chunkSize: len(frame)}); err == nil {
+		t.Fatal("ReadFrame accepted an unknown command")
+	}
+}
+
+func TestReadFrameEnforcesAllowList(t *testing.T) {
+	frame := fixedRequestFrame(CmdInit, []byte{0, 0, 0, 0})
+
+	codec := &ControlCodec{AllowedCommands: map[ControlCommand]bool{CmdShutdown: true}}
+	if _, err := codec.ReadFrame(&chunkReader{data: frame, chunkSize: len(frame)}); err == nil {
+		t.Fatal("ReadFrame accepted a command not present in AllowedCommands")
+	}
+}
+
+func TestReadResponseUnboundedLength(t *testing.T) {
+	// CMD_GET_STATEBLOB's response has no declared upper bound; make sure
+	// a large-ish response still reassembles correctly across reads.
+	data := make([]byte, 5000)
+	frame := make([]byte, 0, 8+len(data))
+	frame = binary.BigEndian.AppendUint32(frame, TPM_SUCCESS)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+
+	codec := &ControlCodec{}
+	got, err := codec.ReadResponse(&chunkReader{data: frame, chunkSize: 64}, CmdGetStateBlob)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if len(got.Payload) != len(frame) {
+		t.Fatalf("Payload len = %d, want %d", len(got.Payload), len(frame))
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// HealthChecker reports the liveness and readiness of one proxy instance.
+type HealthChecker interface {
+	Name() string
+	Listening() bool        // control socket is listening (liveness)
+	BackendReachable() bool // a recent backend dial succeeded (readiness)
+}
+
+// ServeObservability starts an HTTP server on addr exposing Prometheus
+// metrics at /metrics and aggregated health checks at /healthz (liveness)
+// and /readyz (readiness). It returns once the listener is bound; the
+// server itself runs in a background goroutine until shut down.
+func ServeObservability(addr string, reg prometheus.Gatherer, checkers []HealthChecker) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics address %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", healthHandler(checkers, HealthChecker.Listening))
+	mux.HandleFunc("/readyz", healthHandler(checkers, HealthChecker.BackendReachable))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go server.Serve(ln)
+
+	return server, nil
+}
+
+func healthHandler(checkers []HealthChecker, check func(HealthChecker) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := make(map[string]bool, len(checkers))
+		healthy := true
+		for _, c := range checkers {
+			ok := check(c)
+			status[c.Name()] = ok
+			healthy = healthy && ok
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// managedInstance pairs a running proxy with the configuration it was
+// started from, so Reload can detect whether an instance actually changed.
+type managedInstance struct {
+	proxy  *SwtpmProxy
+	config SwtpmProxyInstance
+	done   chan error
+
+	// cancel stops proxy's StartContext, bounding how long it can block
+	// retrying a backend dial when the instance is stopped or reloaded.
+	cancel context.CancelFunc
+}
+
+// Manager owns the lifecycle of a fleet of SwtpmProxy instances loaded from
+// a multi-tenant configuration file. Each instance runs in its own
+// goroutine and still enforces single-active-connection semantics for its
+// own control socket, but instances run fully in parallel with one another.
+type Manager struct {
+	// MetricsAddr, if non-empty, serves Prometheus metrics and aggregated
+	// /healthz and /readyz checks for every instance. Set before calling
+	// Start.
+	MetricsAddr string
+
+	mu         sync.Mutex
+	configPath string
+	instances  map[string]*managedInstance
+	registry   *prometheus.Registry
+	obsServer  *http.Server
+}
+
+// NewManager creates a Manager for the given configuration file path. Call
+// Start to load the configuration and spawn the configured instances.
+func NewManager(configPath string) *Manager {
+	return &Manager{
+		configPath: configPath,
+		instances:  make(map[string]*managedInstance),
+		registry:   prometheus.NewRegistry(),
+	}
+}
+
+// Start loads the configuration file and spawns one goroutine per
+// configured instance.
+func (m *Manager) Start() error {
+	cfg, err := LoadConfig(m.configPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for _, inst := range cfg.Instances {
+		m.startInstanceLocked(inst)
+	}
+	m.mu.Unlock()
+
+	if m.MetricsAddr != "" {
+		checkers := make([]HealthChecker, 0, len(m.instances))
+		for _, mi := range m.instances {
+			checkers = append(checkers, mi.proxy)
+		}
+		server, err := ServeObservability(m.MetricsAddr, m.registry, checkers)
+		if err != nil {
+			return err
+		}
+		m.obsServer = server
+	}
+
+	return nil
+}
+
+func (m *Manager) startInstanceLocked(inst SwtpmProxyInstance) {
+	opts := inst.SwtpmProxyOptions
+	opts.InstanceName = inst.Name
+	proxy := NewSwtpmProxy(opts)
+	proxy.Metrics = NewPrometheusMetrics(m.registry, inst.Name)
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m.instances[inst.Name] = &managedInstance{
+		proxy:  proxy,
+		config: inst,
+		done:   done,
+		cancel: cancel,
+	}
+
+	go func() {
+		proxy.logger().Info("starting instance", "control_socket", inst.ControlSocketPath)
+		err := proxy.StartContext(ctx)
+		if err != nil {
+			proxy.logger().Error("instance stopped", "error", err)
+		}
+		done <- err
+	}()
+}
+
+// unregisterInstanceLocked removes mi's Prometheus collectors from the
+// shared registry, if it has any, so the instance name can be re-registered
+// cleanly by a later startInstanceLocked (Reload restarting it, or Start
+// picking it back up under the same name).
+func (m *Manager) unregisterInstanceLocked(mi *managedInstance) {
+	if pm, ok := mi.proxy.Metrics.(*PrometheusMetrics); ok {
+		pm.Unregister(m.registry)
+	}
+}
+
+// Stop shuts down every running instance.
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for name, mi := range m.instances {
+		mi.cancel()
+		if err := mi.proxy.Stop(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("instance %s: %w", name, err)
+		}
+		m.unregisterInstanceLocked(mi)
+		delete(m.instances, name)
+	}
+
+	if m.obsServer != nil {
+		if err := m.obsServer.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("observability server: %w", err)
+		}
+		m.obsServer = nil
+	}
+
+	return firstErr
+}
+
+// Reload re-reads the configuration file and reconciles the running
+// instances with it: instances that were removed or changed are stopped,
+// and new or changed instances are (re)started.
+func (m *Manager) Reload() error {
+	cfg, err := LoadConfig(m.configPath)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]SwtpmProxyInstance, len(cfg.Instances))
+	for _, inst := range cfg.Instances {
+		wanted[inst.Name] = inst
+	}
+
+	for name, mi := range m.instances {
+		inst, ok := wanted[name]
+		if ok && reflect.DeepEqual(inst, mi.config) {
+			continue
+		}
+
+		mi.cancel()
+		if err := mi.proxy.Stop(); err != nil {
+			slog.Default().Error("error stopping instance for reload", "instance", name, "error", err)
+		}
+		m.unregisterInstanceLocked(mi)
+		delete(m.instances, name)
+	}
+
+	for name, inst := range wanted {
+		if _, running := m.instances[name]; !running {
+			m.startInstanceLocked(inst)
+		}
+	}
+
+	return nil
+}
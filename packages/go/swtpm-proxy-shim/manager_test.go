@@ -0,0 +1,107 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeManagerConfig(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestManagerReloadReconciliation exercises Reload's add/change/remove
+// diffing, and confirms that restarting an instance under a name it
+// previously held does not panic on duplicate Prometheus collector
+// registration (see PrometheusMetrics.Unregister).
+func TestManagerReloadReconciliation(t *testing.T) {
+	dir := t.TempDir()
+	configPath := writeManagerConfig(t, dir, `
+instances:
+  - name: alice
+    controlSocketPath: `+filepath.Join(dir, "alice.sock")+`
+  - name: bob
+    controlSocketPath: `+filepath.Join(dir, "bob.sock")+`
+`)
+
+	m := NewManager(configPath)
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer m.Stop()
+
+	assertInstances(t, m, "alice", "bob")
+
+	// Remove bob, change alice's socket path, add carol.
+	writeManagerConfig(t, dir, `
+instances:
+  - name: alice
+    controlSocketPath: `+filepath.Join(dir, "alice-renamed.sock")+`
+  - name: carol
+    controlSocketPath: `+filepath.Join(dir, "carol.sock")+`
+`)
+
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	assertInstances(t, m, "alice", "carol")
+
+	m.mu.Lock()
+	aliceSocket := m.instances["alice"].config.ControlSocketPath
+	m.mu.Unlock()
+	if want := filepath.Join(dir, "alice-renamed.sock"); aliceSocket != want {
+		t.Errorf("alice's control socket = %q, want %q", aliceSocket, want)
+	}
+
+	// Reloading again with an unchanged config restarts nothing, and must
+	// not panic re-registering metrics for instances that did not change.
+	if err := m.Reload(); err != nil {
+		t.Fatalf("second Reload: %v", err)
+	}
+	assertInstances(t, m, "alice", "carol")
+
+	// Bob's name is gone, then comes back under a fresh config: this must
+	// re-register its metrics cleanly rather than colliding with the
+	// already-unregistered originals.
+	writeManagerConfig(t, dir, `
+instances:
+  - name: alice
+    controlSocketPath: `+filepath.Join(dir, "alice-renamed.sock")+`
+  - name: bob
+    controlSocketPath: `+filepath.Join(dir, "bob-again.sock")+`
+`)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("third Reload: %v", err)
+	}
+	assertInstances(t, m, "alice", "bob")
+}
+
+func assertInstances(t *testing.T, m *Manager, want ...string) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.instances) != len(want) {
+		t.Fatalf("instances = %v, want %v", instanceNames(m), want)
+	}
+	for _, name := range want {
+		if _, ok := m.instances[name]; !ok {
+			t.Fatalf("missing instance %q, have %v", name, instanceNames(m))
+		}
+	}
+}
+
+func instanceNames(m *Manager) []string {
+	names := make([]string, 0, len(m.instances))
+	for name := range m.instances {
+		names = append(names, name)
+	}
+	return names
+}
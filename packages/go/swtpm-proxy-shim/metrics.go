@@ -0,0 +1,161 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records proxy activity. Production code uses PrometheusMetrics;
+// tests can supply a no-op implementation (the zero value of Metrics is
+// nil, and every call site falls back to noopMetrics).
+type Metrics interface {
+	ConnectionAccepted()
+	BackendDialAttempt()
+	BackendDialFailure()
+	BackendDialRetry()
+	BackendDialDuration(d time.Duration)
+	ControlCommand(cmd ControlCommand)
+	BytesProxied(channel, direction string, n int)
+	SessionStarted()
+	SessionEnded(d time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ConnectionAccepted()               {}
+func (noopMetrics) BackendDialAttempt()               {}
+func (noopMetrics) BackendDialFailure()               {}
+func (noopMetrics) BackendDialRetry()                 {}
+func (noopMetrics) BackendDialDuration(time.Duration) {}
+func (noopMetrics) ControlCommand(ControlCommand)     {}
+func (noopMetrics) BytesProxied(string, string, int)  {}
+func (noopMetrics) SessionStarted()                   {}
+func (noopMetrics) SessionEnded(time.Duration)        {}
+
+// PrometheusMetrics implements Metrics on top of a prometheus.Registerer.
+type PrometheusMetrics struct {
+	connectionsAccepted prometheus.Counter
+	dialAttempts        prometheus.Counter
+	dialFailures        prometheus.Counter
+	dialRetries         prometheus.Counter
+	dialDuration        prometheus.Histogram
+	controlCommands     *prometheus.CounterVec
+	bytesProxied        *prometheus.CounterVec
+	sessionsInFlight    prometheus.Gauge
+	sessionDuration     prometheus.Histogram
+}
+
+// NewPrometheusMetrics registers and returns the metrics for one instance.
+// The instance name is attached as a constant label so multiple instances
+// can share a single registry.
+func NewPrometheusMetrics(reg prometheus.Registerer, instance string) *PrometheusMetrics {
+	labels := prometheus.Labels{"instance": instance}
+
+	m := &PrometheusMetrics{
+		connectionsAccepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_connections_accepted_total",
+			Help:        "Number of QEMU control socket connections accepted.",
+			ConstLabels: labels,
+		}),
+		dialAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_backend_dial_attempts_total",
+			Help:        "Number of attempts made to dial the swtpm backend.",
+			ConstLabels: labels,
+		}),
+		dialFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_backend_dial_failures_total",
+			Help:        "Number of failed attempts to dial the swtpm backend.",
+			ConstLabels: labels,
+		}),
+		dialRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_backend_dial_retries_total",
+			Help:        "Number of retries performed while dialing the swtpm backend.",
+			ConstLabels: labels,
+		}),
+		dialDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "swtpm_proxy_backend_dial_duration_seconds",
+			Help:        "Latency of successful backend dials.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}),
+		controlCommands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_control_commands_total",
+			Help:        "Control channel commands proxied, by opcode.",
+			ConstLabels: labels,
+		}, []string{"command"}),
+		bytesProxied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "swtpm_proxy_bytes_proxied_total",
+			Help:        "Bytes proxied, by channel and direction.",
+			ConstLabels: labels,
+		}, []string{"channel", "direction"}),
+		sessionsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "swtpm_proxy_sessions_in_flight",
+			Help:        "Number of QEMU<->swtpm sessions currently being proxied.",
+			ConstLabels: labels,
+		}),
+		sessionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "swtpm_proxy_session_duration_seconds",
+			Help:        "Duration of completed QEMU<->swtpm sessions.",
+			ConstLabels: labels,
+			Buckets:     prometheus.DefBuckets,
+		}),
+	}
+
+	reg.MustRegister(
+		m.connectionsAccepted,
+		m.dialAttempts,
+		m.dialFailures,
+		m.dialRetries,
+		m.dialDuration,
+		m.controlCommands,
+		m.bytesProxied,
+		m.sessionsInFlight,
+		m.sessionDuration,
+	)
+
+	return m
+}
+
+// Unregister removes all of m's collectors from reg. Callers must do this
+// before registering a replacement for the same instance name (e.g. Manager
+// restarting an instance on Reload), since re-registering a collector with
+// the same name and ConstLabels without first unregistering the old one
+// panics.
+func (m *PrometheusMetrics) Unregister(reg prometheus.Registerer) {
+	reg.Unregister(m.connectionsAccepted)
+	reg.Unregister(m.dialAttempts)
+	reg.Unregister(m.dialFailures)
+	reg.Unregister(m.dialRetries)
+	reg.Unregister(m.dialDuration)
+	reg.Unregister(m.controlCommands)
+	reg.Unregister(m.bytesProxied)
+	reg.Unregister(m.sessionsInFlight)
+	reg.Unregister(m.sessionDuration)
+}
+
+func (m *PrometheusMetrics) ConnectionAccepted() { m.connectionsAccepted.Inc() }
+func (m *PrometheusMetrics) BackendDialAttempt() { m.dialAttempts.Inc() }
+func (m *PrometheusMetrics) BackendDialFailure() { m.dialFailures.Inc() }
+func (m *PrometheusMetrics) BackendDialRetry()   { m.dialRetries.Inc() }
+
+func (m *PrometheusMetrics) BackendDialDuration(d time.Duration) {
+	m.dialDuration.Observe(d.Seconds())
+}
+
+func (m *PrometheusMetrics) ControlCommand(cmd ControlCommand) {
+	m.controlCommands.WithLabelValues(cmd.String()).Inc()
+}
+
+func (m *PrometheusMetrics) BytesProxied(channel, direction string, n int) {
+	m.bytesProxied.WithLabelValues(channel, direction).Add(float64(n))
+}
+
+func (m *PrometheusMetrics) SessionStarted() { m.sessionsInFlight.Inc() }
+
+func (m *PrometheusMetrics) SessionEnded(d time.Duration) {
+	m.sessionsInFlight.Dec()
+	m.sessionDuration.Observe(d.Seconds())
+}
@@ -0,0 +1,216 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeHalfCloser is a halfCloser backed by independent pipes for reading and
+// writing, so tests can close one direction (CloseWrite) without affecting
+// the other the way a real duplex socket (net.UnixConn, net.TCPConn,
+// tls.Conn, vsock.Conn) does; net.Pipe cannot stand in for this because its
+// Close always tears down both directions at once.
+type fakeHalfCloser struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (f *fakeHalfCloser) Read(p []byte) (int, error)  { return f.r.Read(p) }
+func (f *fakeHalfCloser) Write(p []byte) (int, error) { return f.w.Write(p) }
+func (f *fakeHalfCloser) Close() error {
+	f.w.Close()
+	f.r.Close()
+	return nil
+}
+func (f *fakeHalfCloser) CloseWrite() error { return f.w.Close() }
+
+// newFakeConnPair returns two ends of an in-memory full-duplex connection:
+// whatever is written to one end's Write is read from the other end's Read.
+func newFakeConnPair() (*fakeHalfCloser, *fakeHalfCloser) {
+	aToBR, aToBW := io.Pipe()
+	bToAR, bToAW := io.Pipe()
+	a := &fakeHalfCloser{r: bToAR, w: aToBW}
+	b := &fakeHalfCloser{r: aToBR, w: bToAW}
+	return a, b
+}
+
+// TestConnChannelProxyHalfCloseKeepsOtherDirectionAlive confirms that a
+// clean EOF in one direction only half-closes its destination, so the
+// other, still-active direction keeps delivering data instead of being
+// torn down.
+func TestConnChannelProxyHalfCloseKeepsOtherDirectionAlive(t *testing.T) {
+	qemuProxySide, qemuTestSide := newFakeConnPair()
+	backendProxySide, backendTestSide := newFakeConnPair()
+
+	c := &ConnChannelProxy{
+		backendConn: backendProxySide,
+		qemuConn:    qemuProxySide,
+		metrics:     noopMetrics{},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.proxy() }()
+
+	// QEMU is done sending: half-close its side immediately, with no data.
+	qemuTestSide.w.Close()
+
+	// The backend->qemu direction must still work even though qemu->backend
+	// already finished.
+	msg := []byte("still alive")
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := backendTestSide.w.Write(msg)
+		writeErr <- err
+	}()
+
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(qemuTestSide, got); err != nil {
+		t.Fatalf("reading from the surviving direction: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Fatalf("got %q, want %q", got, msg)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("write on surviving direction: %v", err)
+	}
+
+	// Let the backend->qemu direction finish too, so proxy() can return.
+	backendTestSide.w.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("proxy() = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy() did not return after both directions finished cleanly")
+	}
+}
+
+// TestConnChannelProxyUnrecoverableErrorClosesBothDirections confirms that
+// a non-EOF error in one direction closes both connections immediately,
+// instead of leaving the other direction's blocked Read to hang forever.
+func TestConnChannelProxyUnrecoverableErrorClosesBothDirections(t *testing.T) {
+	qemuProxySide, qemuTestSide := newFakeConnPair()
+	backendProxySide, _ := newFakeConnPair()
+
+	c := &ConnChannelProxy{
+		backendConn: backendProxySide,
+		qemuConn:    qemuProxySide,
+		metrics:     noopMetrics{},
+	}
+
+	// Break the backend connection's outbound side only, so the
+	// qemu->backend direction's Write fails while the backend->qemu
+	// direction's Read (on the same backendConn) is left blocked with no
+	// data and no EOF of its own, exactly like a one-sided connection
+	// failure.
+	backendProxySide.w.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.proxy() }()
+
+	// Give qemu->backend something to copy, so its Write into the broken
+	// backendConn actually happens.
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := qemuTestSide.w.Write([]byte("ping"))
+		writeErr <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("proxy() = nil, want the unrecoverable write error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy() hung instead of closing both directions after an unrecoverable error")
+	}
+
+	<-writeErr
+}
+
+// TestStartContextSurvivesSetFdFailure confirms that a handleQemuSetFd
+// failure closes the offending connection and the accept loop continues,
+// instead of falling through to chans.Proxy() on a nil *TpmProxyChannels.
+func TestStartContextSurvivesSetFdFailure(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("backend listen: %v", err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	_, portStr, err := net.SplitHostPort(backendLn.Addr().String())
+	if err != nil {
+		t.Fatalf("split backend addr: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("parse backend port: %v", err)
+	}
+
+	sockPath := filepath.Join(t.TempDir(), "control.sock")
+	p := NewSwtpmProxy(SwtpmProxyOptions{
+		ControlSocketPath:        sockPath,
+		BackendType:              BackendIP,
+		BackendAddress:           "127.0.0.1",
+		BackendControlPort:       uint16(port),
+		BackendDataPort:          uint16(port),
+		BackendControlRetryCount: 1,
+		InstanceName:             "test",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	startErr := make(chan error, 1)
+	go func() { startErr <- p.StartContext(ctx) }()
+
+	for i := 0; i < 200 && !p.Listening(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.Listening() {
+		t.Fatal("proxy never started listening")
+	}
+
+	// Two consecutive malformed "QEMU" connections: neither sends a valid
+	// CMD_SET_DATAFD, so handleQemuSetFd fails both times. Before the
+	// `continue` fix, a connection whose setfd failed fell through to
+	// chans.Proxy() on a nil *TpmProxyChannels and panicked the process.
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", sockPath)
+		if err != nil {
+			t.Fatalf("dial control socket: %v", err)
+		}
+		if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+			t.Fatalf("write malformed setfd command: %v", err)
+		}
+		result := make([]byte, 4)
+		conn.Read(result) //nolint:errcheck // best-effort drain of the TPM_FAIL reply
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-startErr:
+		if err != context.Canceled {
+			t.Fatalf("StartContext() = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("StartContext did not return after ctx cancellation")
+	}
+}
@@ -3,18 +3,36 @@
 package swtpmproxy
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/mdlayher/vsock"
 )
 
+// Defaults applied when SwtpmProxyOptions leaves the backend dial backoff
+// fields at their zero value.
+const (
+	defaultBackendDialInitialBackoff = 2 * time.Second
+	defaultBackendDialMaxBackoff     = 30 * time.Second
+)
+
+// probeFreshness bounds how long a successful backend dial counts as
+// evidence of readiness for /readyz.
+const probeFreshness = 60 * time.Second
+
 const (
 	CMD_SET_DATAFD = 0x10
 	TPM_SUCCESS    = 0
@@ -32,39 +50,344 @@ type BackendChannel struct {
 	port uint16
 }
 
+// halfCloser is a connection that can close its write side independently of
+// its read side, so a peer finishing a write while the other side is still
+// draining its response isn't torn down prematurely.
+type halfCloser interface {
+	io.ReadWriteCloser
+	CloseWrite() error
+}
+
+// noHalfCloser adapts a connection with no native half-close support to
+// halfCloser by falling back to a full Close. None of the connection types
+// this proxy actually uses (net.UnixConn, net.TCPConn, tls.Conn, vsock.Conn)
+// need it; it exists so asHalfCloser is total.
+type noHalfCloser struct {
+	io.ReadWriteCloser
+}
+
+func (n noHalfCloser) CloseWrite() error {
+	return n.Close()
+}
+
+// asHalfCloser wraps a backend or QEMU connection so Proxy can half-close it
+// on EOF instead of closing it outright.
+func asHalfCloser(conn io.ReadWriteCloser) halfCloser {
+	if hc, ok := conn.(halfCloser); ok {
+		return hc
+	}
+	return noHalfCloser{conn}
+}
+
 type ConnChannelProxy struct {
-	backendConn io.ReadWriteCloser
-	qemuConn    io.ReadWriteCloser
+	backendConn halfCloser
+	qemuConn    halfCloser
+
+	// channelName and metrics label the bytes proxied by this channel; set
+	// by handleQemuSetFd.
+	channelName string
+	metrics     Metrics
+}
+
+// proxy copies data in both directions between backendConn and qemuConn. On
+// a clean EOF in either direction, it half-closes the destination's write
+// side rather than closing the whole connection, so the other direction can
+// keep draining in-flight data. If either direction instead fails with an
+// unrecoverable error, both ends are closed immediately so the peer
+// direction's blocking Read/Write is not left to hang forever; otherwise
+// both ends are closed once both directions have finished.
+func (c *ConnChannelProxy) proxy() error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, 2)
+
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			c.backendConn.Close()
+			c.qemuConn.Close()
+		})
+	}
+
+	metrics := c.metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	direction := func(dst, src halfCloser, dirLabel string) {
+		defer wg.Done()
+		n, err := io.Copy(dst, src)
+		metrics.BytesProxied(c.channelName, dirLabel, int(n))
+		if err != nil {
+			errCh <- err
+			closeBoth()
+			return
+		}
+		errCh <- dst.CloseWrite()
+	}
+
+	wg.Add(2)
+	go direction(c.backendConn, c.qemuConn, "qemu_to_backend")
+	go direction(c.qemuConn, c.backendConn, "backend_to_qemu")
+	wg.Wait()
+	close(errCh)
+
+	var firstErr error
+	for err := range errCh {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	closeBoth()
+
+	return firstErr
+}
+
+// proxyControl forwards the swtpm control protocol one request/response
+// exchange at a time instead of blindly copying bytes, so codec can reject
+// malformed or disallowed commands before they reach the backend and emit
+// an audit record for every exchange.
+func (c *ConnChannelProxy) proxyControl(codec *ControlCodec) error {
+	defer c.backendConn.Close()
+	defer c.qemuConn.Close()
+
+	for {
+		req, err := codec.ReadFrame(c.qemuConn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			resp := rejectionResponse()
+			if _, werr := codec.WriteFrame(c.qemuConn, resp); werr != nil {
+				return fmt.Errorf("control channel: failed to write rejection for %v: %w", err, werr)
+			}
+			codec.audit(0, resultCode(resp.Payload), 0, len(resp.Payload))
+
+			// Only a policy denial is guaranteed to have consumed exactly
+			// one full frame; every other ReadFrame error (unknown
+			// command, a length-rule violation, a short read, ...) may
+			// leave unread bytes of that frame on the wire, and the next
+			// ReadFrame would misinterpret them as a new command header.
+			// There is no safe way to resync, so close the channel.
+			if errors.Is(err, errCommandDenied) {
+				continue
+			}
+			return fmt.Errorf("control channel: unrecoverable frame error, closing: %w", err)
+		}
+
+		if _, err := c.backendConn.Write(req.Payload); err != nil {
+			return fmt.Errorf("control channel: failed to forward request to backend: %w", err)
+		}
+
+		resp, err := codec.ReadResponse(c.backendConn, req.Command)
+		if err != nil {
+			return fmt.Errorf("control channel: failed to read backend response: %w", err)
+		}
+
+		if _, err := codec.WriteFrame(c.qemuConn, resp); err != nil {
+			return fmt.Errorf("control channel: failed to forward response to qemu: %w", err)
+		}
+
+		codec.metrics().BytesProxied("control", "qemu_to_backend", len(req.Payload))
+		codec.metrics().BytesProxied("control", "backend_to_qemu", len(resp.Payload))
+		codec.audit(req.Command, resultCode(resp.Payload), len(req.Payload), len(resp.Payload))
+	}
 }
 
 type TpmProxyChannels struct {
 	controlChannel ConnChannelProxy
 	dataChannel    ConnChannelProxy
+
+	// controlCodec parses, validates and audits the control channel. It is
+	// always set by handleQemuSetFd.
+	controlCodec *ControlCodec
 }
 
 type SwtpmProxyOptions struct {
-	ControlSocketPath string // Path to the UNIX socket to listen on
+	ControlSocketPath string `yaml:"controlSocketPath"` // Path to the UNIX socket to listen on
+
+	BackendType    BackendType `yaml:"backendType"`    // Type of backend connection (IP or Vsock)
+	BackendAddress string      `yaml:"backendAddress"` // Remote address to connect to
+	BackendCid     uint32      `yaml:"backendCid"`     // CID for vsock connections
+
+	BackendControlPort uint16 `yaml:"backendControlPort"` // Port for backend control connection
+	BackendDataPort    uint16 `yaml:"backendDataPort"`    // Port for backend data connection
+
+	BackendControlRetryCount int `yaml:"backendControlRetryCount"` // Number of attempts (the first dial included) for backend control connection
+
+	// BackendDialInitialBackoff is the delay before the first retry, and the
+	// base of the exponential backoff applied to subsequent retries.
+	// Defaults to 2s if zero.
+	BackendDialInitialBackoff time.Duration `yaml:"backendDialInitialBackoff"`
+
+	// BackendDialMaxBackoff caps the exponential backoff delay between
+	// dial retries. Defaults to 30s if zero.
+	BackendDialMaxBackoff time.Duration `yaml:"backendDialMaxBackoff"`
+
+	// BackendDialJitter applies full jitter to the backoff delay (the
+	// actual delay is chosen uniformly at random between 0 and the
+	// computed backoff), spreading out retries from multiple instances
+	// reconnecting at once.
+	BackendDialJitter bool `yaml:"backendDialJitter"`
+
+	// BackendTLS configures TLS for BackendIP connections, for deployments
+	// where swtpm runs on a separate host across an untrusted network.
+	BackendTLS BackendTLSConfig `yaml:"backendTLS"`
+
+	// RequireTLS refuses to start with a plain TCP backend, so operators
+	// can guarantee encryption at boot.
+	RequireTLS bool `yaml:"requireTLS"`
+
+	// AllowedControlCommands restricts which control commands are forwarded
+	// to the backend, by swtpm command name (e.g. "CMD_INIT"). An empty
+	// list allows every known command; use this to deny commands such as
+	// CMD_SET_STATEBLOB in production.
+	AllowedControlCommands []string `yaml:"allowedControlCommands"`
+
+	// InstanceName identifies this proxy in audit records and logs. It is
+	// set programmatically (e.g. by Manager, from SwtpmProxyInstance.Name)
+	// rather than parsed from the options themselves.
+	InstanceName string `yaml:"-"`
+}
 
-	BackendType    BackendType // Type of backend connection (IP or Vsock)
-	BackendAddress string      // Remote address to connect to
-	BackendCid     uint32      // CID for vsock connections
+// BackendTLSConfig wraps a BackendIP transport in mTLS.
+type BackendTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
 
-	BackendControlPort uint16 // Port for backend control connection
-	BackendDataPort    uint16 // Port for backend data connection
+	CAFile   string `yaml:"caFile"`   // PEM CA bundle used to verify the backend's certificate
+	CertFile string `yaml:"certFile"` // Client certificate presented to the backend
+	KeyFile  string `yaml:"keyFile"`  // Private key for CertFile
 
-	BackendControlRetryCount int // Number of retries for backend control connection
+	ServerName         string `yaml:"serverName"`         // Expected backend certificate name, overrides BackendAddress
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"` // Disable backend certificate verification (testing only)
+}
+
+// tlsConfig builds a *tls.Config for dialing the backend from the options,
+// loading the client certificate and CA bundle from disk.
+func (c BackendTLSConfig) tlsConfig(fallbackServerName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = fallbackServerName
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate/key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", c.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse CA bundle %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// UnmarshalYAML lets BackendType be written as "tcp" or "vsock" in a config
+// file instead of its underlying integer value.
+func (t *BackendType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	switch s {
+	case "tcp", "ip":
+		*t = BackendIP
+	case "vsock":
+		*t = BackendVsock
+	default:
+		return fmt.Errorf("unsupported backend type %q, expected 'tcp' or 'vsock'", s)
+	}
+
+	return nil
 }
 
 type SwtpmProxy struct {
 	Options SwtpmProxyOptions
+
+	// Logger and Metrics default to a per-instance slog logger and a no-op
+	// Metrics implementation respectively; callers (e.g. Manager) may
+	// override them, and tests can supply a no-op/fake Metrics.
+	Logger  *slog.Logger
+	Metrics Metrics
+
+	mu       sync.Mutex
+	listener net.Listener
+
+	lastProbeOK atomic.Bool
+	lastProbeAt atomic.Int64 // UnixNano of the last backend dial outcome
 }
 
 func NewSwtpmProxy(options SwtpmProxyOptions) *SwtpmProxy {
+	backend := "vsock"
+	if options.BackendType == BackendIP {
+		backend = "tcp"
+	}
+
+	logger := slog.Default().With("instance", options.InstanceName, "backend", backend)
+	if options.BackendType == BackendIP {
+		logger = logger.With("addr", options.BackendAddress)
+	} else {
+		logger = logger.With("cid", options.BackendCid)
+	}
+
 	return &SwtpmProxy{
 		Options: options,
+		Logger:  logger,
 	}
 }
 
+func (p *SwtpmProxy) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default()
+}
+
+func (p *SwtpmProxy) metrics() Metrics {
+	if p.Metrics != nil {
+		return p.Metrics
+	}
+	return noopMetrics{}
+}
+
+// Name identifies this proxy for HealthChecker/audit purposes.
+func (p *SwtpmProxy) Name() string {
+	return p.Options.InstanceName
+}
+
+// Listening reports whether the control socket is currently being accepted
+// on, for /healthz.
+func (p *SwtpmProxy) Listening() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listener != nil
+}
+
+// BackendReachable reports whether the most recent backend dial succeeded
+// recently enough to still be meaningful, for /readyz.
+func (p *SwtpmProxy) BackendReachable() bool {
+	if !p.lastProbeOK.Load() {
+		return false
+	}
+	return time.Since(time.Unix(0, p.lastProbeAt.Load())) < probeFreshness
+}
+
 func isSetDataFdCmd(buf []byte) error {
 
 	if len(buf) < 4 {
@@ -97,7 +420,7 @@ func parseSetDataOob(buf []byte) (uint32, error) {
 	return binary.NativeEndian.Uint32(scmVal.Data[:4]), nil
 }
 
-func (p *SwtpmProxy) handleQemuSetFd(backendControl io.ReadWriteCloser, qemuControl *net.UnixConn) (*TpmProxyChannels, error) {
+func (p *SwtpmProxy) handleQemuSetFd(backendControl halfCloser, qemuControl *net.UnixConn) (*TpmProxyChannels, error) {
 	const bufferSize = 4096
 	buf := make([]byte, bufferSize)
 	oob := make([]byte, bufferSize)
@@ -125,18 +448,38 @@ func (p *SwtpmProxy) handleQemuSetFd(backendControl io.ReadWriteCloser, qemuCont
 		return nil, err
 	}
 
-	qemuDataChan := os.NewFile(uintptr(fd), "data_fd")
-	if qemuDataChan == nil {
+	qemuDataFile := os.NewFile(uintptr(fd), "data_fd")
+	if qemuDataFile == nil {
 		return nil, fmt.Errorf("received an invalid file descriptor from qemu: %d", fd)
 	}
+	// Wrap the raw fd as a *net.UnixConn so it supports CloseWrite for
+	// half-close; net.FileConn dup()s the fd, so the original file is
+	// closed either way.
+	qemuDataGenericConn, err := net.FileConn(qemuDataFile)
+	qemuDataFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap qemu data fd as a connection: %w", err)
+	}
+	qemuDataConn, ok := qemuDataGenericConn.(*net.UnixConn)
+	if !ok {
+		qemuDataGenericConn.Close()
+		return nil, fmt.Errorf("qemu data fd %d is not a unix socket", fd)
+	}
 
 	backendDataConn, err := p.dialBackend(BackendChannel{p.Options.BackendDataPort})
 
 	if err != nil {
-		qemuDataChan.Close()
+		qemuDataConn.Close()
 		return nil, fmt.Errorf("failed dialing to backend data channel: %w", err)
 	}
 
+	allowedCommands, err := allowedCommandSet(p.Options.AllowedControlCommands)
+	if err != nil {
+		qemuDataConn.Close()
+		backendDataConn.Close()
+		return nil, fmt.Errorf("invalid control command policy: %w", err)
+	}
+
 	tpmResult = TPM_SUCCESS
 	return &TpmProxyChannels{
 		controlChannel: ConnChannelProxy{
@@ -145,44 +488,110 @@ func (p *SwtpmProxy) handleQemuSetFd(backendControl io.ReadWriteCloser, qemuCont
 		},
 		dataChannel: ConnChannelProxy{
 			backendConn: backendDataConn,
-			qemuConn:    qemuDataChan,
+			qemuConn:    qemuDataConn,
+			channelName: "data",
+			metrics:     p.metrics(),
+		},
+		controlCodec: &ControlCodec{
+			Instance:        p.Options.InstanceName,
+			AllowedCommands: allowedCommands,
+			Logger:          p.logger(),
+			Metrics:         p.metrics(),
 		},
 	}, nil
 }
 
-func (p *SwtpmProxy) dialBackend(channel BackendChannel) (io.ReadWriteCloser, error) {
+func (p *SwtpmProxy) dialBackend(channel BackendChannel) (halfCloser, error) {
+	start := time.Now()
+	p.metrics().BackendDialAttempt()
+
 	var backendConn io.ReadWriteCloser
 	var err error
 
 	switch p.Options.BackendType {
 	case BackendIP:
-		backendConn, err = net.Dial("tcp", net.JoinHostPort(p.Options.BackendAddress, fmt.Sprintf("%d", channel.port)))
+		addr := net.JoinHostPort(p.Options.BackendAddress, fmt.Sprintf("%d", channel.port))
+		if p.Options.BackendTLS.Enabled {
+			var tlsCfg *tls.Config
+			tlsCfg, err = p.Options.BackendTLS.tlsConfig(p.Options.BackendAddress)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build backend TLS config: %w", err)
+			}
+			backendConn, err = tls.Dial("tcp", addr, tlsCfg)
+		} else {
+			backendConn, err = net.Dial("tcp", addr)
+		}
 	case BackendVsock:
 		backendConn, err = vsock.Dial(p.Options.BackendCid, uint32(channel.port), nil)
 	default:
 		err = fmt.Errorf("unsupported backend type '%d' specified", p.Options.BackendType)
 	}
 
+	p.lastProbeAt.Store(time.Now().UnixNano())
 	if err != nil {
+		p.metrics().BackendDialFailure()
+		p.lastProbeOK.Store(false)
 		return nil, fmt.Errorf("failed dialing to backend: %w", err)
 	}
+	p.lastProbeOK.Store(true)
+	p.metrics().BackendDialDuration(time.Since(start))
+
+	return asHalfCloser(backendConn), nil
+}
+
+// backendDialBackoff computes the delay before retry attempt (0-indexed),
+// as exponential backoff from base capped at cap, with optional full
+// jitter (RFC-less, but see AWS's "Exponential Backoff And Jitter" post):
+// sleep = rand(0, min(cap, base * 2^attempt)).
+func backendDialBackoff(attempt int, base, cap time.Duration, jitter bool) time.Duration {
+	if base <= 0 {
+		base = defaultBackendDialInitialBackoff
+	}
+	if cap <= 0 {
+		cap = defaultBackendDialMaxBackoff
+	}
 
-	return backendConn, nil
+	delay := base
+	for i := 0; i < attempt && delay < cap; i++ {
+		delay *= 2
+	}
+	if delay > cap {
+		delay = cap
+	}
+
+	if !jitter || delay <= 0 {
+		return delay
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
 }
 
-func (p *SwtpmProxy) dialBackendWithRetry(channel BackendChannel, maxRetries int, retryDelay time.Duration) (io.ReadWriteCloser, error) {
+// dialBackendWithRetry dials the backend, retrying with exponential backoff
+// on failure (with full jitter when BackendDialJitter is set). The sleep
+// between attempts is interrupted by ctx cancellation so a SIGTERM can stop a
+// proxy that is stuck waiting for a not-yet-ready backend; a dial already in
+// progress is not interrupted by ctx.
+func (p *SwtpmProxy) dialBackendWithRetry(ctx context.Context, channel BackendChannel, maxRetries int) (halfCloser, error) {
 	var lastErr error
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
-			fmt.Printf("Retrying backend connection (attempt %d/%d) in %v...\n", attempt+1, maxRetries, retryDelay)
-			time.Sleep(retryDelay)
+			delay := backendDialBackoff(attempt-1, p.Options.BackendDialInitialBackoff, p.Options.BackendDialMaxBackoff, p.Options.BackendDialJitter)
+			p.metrics().BackendDialRetry()
+			p.logger().Warn("retrying backend connection", "attempt", attempt+1, "max_attempts", maxRetries, "delay", delay)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
 		}
 
 		conn, err := p.dialBackend(channel)
 		if err == nil {
 			if attempt > 0 {
-				fmt.Printf("Successfully connected to backend after %d retries\n", attempt)
+				p.logger().Info("connected to backend after retries", "attempts", attempt)
 			}
 			return conn, nil
 		}
@@ -193,7 +602,22 @@ func (p *SwtpmProxy) dialBackendWithRetry(channel BackendChannel, maxRetries int
 	return nil, fmt.Errorf("failed to connect to backend after %d attempts: %w", maxRetries, lastErr)
 }
 
+// Start listens on the control socket and serves connections until it
+// fails or is stopped, with no shutdown deadline beyond Stop's own.
+// Equivalent to StartContext(context.Background()).
 func (p *SwtpmProxy) Start() error {
+	return p.StartContext(context.Background())
+}
+
+// StartContext is Start, but ctx cancellation additionally interrupts any
+// in-progress backend dial retry and closes the listener, so a caller can
+// bound shutdown time (e.g. on SIGTERM) instead of waiting out the full
+// retry backoff.
+func (p *SwtpmProxy) StartContext(ctx context.Context) error {
+	if p.Options.RequireTLS && (p.Options.BackendType != BackendIP || !p.Options.BackendTLS.Enabled) {
+		return fmt.Errorf("require-tls is set but the backend is not a TLS-enabled TCP backend")
+	}
+
 	if _, err := os.Stat(p.Options.ControlSocketPath); err == nil {
 		err := os.Remove(p.Options.ControlSocketPath)
 		if err != nil {
@@ -207,13 +631,40 @@ func (p *SwtpmProxy) Start() error {
 		return fmt.Errorf("failed to listen on unix socket %s: %w", p.Options.ControlSocketPath, err)
 	}
 
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if p.listener == l {
+			p.listener = nil
+		}
+		p.mu.Unlock()
+	}()
+
 	defer l.Close()
 
+	// Unblock Accept (and any pending dial retry, via ctx.Done in
+	// dialBackendWithRetry) when ctx is cancelled.
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-stopWatching:
+		}
+	}()
+
 	for {
 		clientConn, err := l.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			return fmt.Errorf("accept error: %w", err)
 		}
+		p.metrics().ConnectionAccepted()
 
 		qemuControlConn, ok := clientConn.(*net.UnixConn)
 		if !ok {
@@ -222,57 +673,76 @@ func (p *SwtpmProxy) Start() error {
 		}
 
 		// Retry control channel connection for TPM-VM boot delay
-		fmt.Println("Connecting to backend control channel...")
-		swtpmControlConn, err := p.dialBackendWithRetry(BackendChannel{p.Options.BackendControlPort}, p.Options.BackendControlRetryCount, 2*time.Second)
+		p.logger().Info("connecting to backend control channel")
+		swtpmControlConn, err := p.dialBackendWithRetry(ctx, BackendChannel{p.Options.BackendControlPort}, p.Options.BackendControlRetryCount)
 
 		if err != nil {
 			clientConn.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			return fmt.Errorf("failed dialing to backend control channel after retries: %w", err)
 		}
-		fmt.Println("New connection established, handling QEMU setfd command...")
+		p.logger().Info("new connection established, handling QEMU setfd command")
 
 		chans, err := p.handleQemuSetFd(swtpmControlConn, qemuControlConn)
 
 		if err != nil {
-			fmt.Printf("Error handling QEMU setfd command: %v\n", err)
+			p.logger().Error("error handling QEMU setfd command", "error", err)
 			swtpmControlConn.Close()
 			qemuControlConn.Close()
+			continue
 		}
 
-		fmt.Println("setfd parsed successfully")
-		fmt.Println("Starting proxy channels...")
+		p.logger().Info("setfd parsed successfully, starting proxy channels")
+
+		p.metrics().SessionStarted()
+		sessionStart := time.Now()
 
 		// We don't want to handle multiple connections for the same vTPM instance, run this synchronously
 		err = chans.Proxy()
 		if err != nil {
-			fmt.Printf("Error during proxying: %v\n", err)
+			p.logger().Error("error during proxying", "error", err)
 		}
+
+		p.metrics().SessionEnded(time.Since(sessionStart))
 	}
 
 }
 
-func (p *TpmProxyChannels) Proxy() error {
-	errCh := make(chan error, 4)
+// Stop closes the control socket listener, causing a blocked Start to
+// return. It is safe to call even if Start has not been called yet, or has
+// already returned.
+func (p *SwtpmProxy) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	proxy := func(dst io.WriteCloser, src io.ReadCloser) {
-		_, err := io.Copy(dst, src)
-		errCh <- err
+	if p.listener == nil {
+		return nil
 	}
 
-	var wg sync.WaitGroup
-	wg.Go(func() { proxy(p.controlChannel.backendConn, p.controlChannel.qemuConn) })
-	wg.Go(func() { proxy(p.controlChannel.qemuConn, p.controlChannel.backendConn) })
-	wg.Go(func() { proxy(p.dataChannel.backendConn, p.dataChannel.qemuConn) })
-	wg.Go(func() { proxy(p.dataChannel.qemuConn, p.dataChannel.backendConn) })
-
-	err := <-errCh
+	err := p.listener.Close()
+	p.listener = nil
+	return err
+}
 
-	p.controlChannel.backendConn.Close()
-	p.controlChannel.qemuConn.Close()
-	p.dataChannel.backendConn.Close()
-	p.dataChannel.qemuConn.Close()
+// Proxy runs the control and data channels independently, so a finished or
+// failed control channel does not tear down an in-flight data transfer (and
+// vice versa). See ConnChannelProxy.proxy for the half-close handling within
+// each channel.
+func (p *TpmProxyChannels) Proxy() error {
+	var controlErr, dataErr error
 
+	var wg sync.WaitGroup
+	wg.Go(func() { controlErr = p.controlChannel.proxyControl(p.controlCodec) })
+	wg.Go(func() { dataErr = p.dataChannel.proxy() })
 	wg.Wait()
 
-	return err
+	if controlErr != nil {
+		return fmt.Errorf("control channel: %w", controlErr)
+	}
+	if dataErr != nil {
+		return fmt.Errorf("data channel: %w", dataErr)
+	}
+	return nil
 }
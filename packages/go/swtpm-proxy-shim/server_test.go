@@ -0,0 +1,58 @@
+// Copyright 2022-2025 TII (SSRC) and the Ghaf contributors
+// SPDX-License-Identifier: Apache-2.0
+package swtpmproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackendDialBackoffBounds(t *testing.T) {
+	base := 2 * time.Second
+	cap := 30 * time.Second
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 2 * time.Second},
+		{1, 4 * time.Second},
+		{2, 8 * time.Second},
+		{3, 16 * time.Second},
+		{4, 30 * time.Second},  // would be 32s, capped
+		{10, 30 * time.Second}, // stays capped
+	}
+
+	for _, tc := range cases {
+		if got := backendDialBackoff(tc.attempt, base, cap, false); got != tc.want {
+			t.Errorf("backendDialBackoff(%d, jitter=false) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestBackendDialBackoffDefaults(t *testing.T) {
+	got := backendDialBackoff(0, 0, 0, false)
+	if got != defaultBackendDialInitialBackoff {
+		t.Errorf("backendDialBackoff with zero base = %v, want default %v", got, defaultBackendDialInitialBackoff)
+	}
+
+	got = backendDialBackoff(30, 0, 0, false)
+	if got != defaultBackendDialMaxBackoff {
+		t.Errorf("backendDialBackoff with zero cap = %v, want default max %v", got, defaultBackendDialMaxBackoff)
+	}
+}
+
+func TestBackendDialBackoffJitter(t *testing.T) {
+	base := 2 * time.Second
+	cap := 30 * time.Second
+
+	for attempt := 0; attempt < 5; attempt++ {
+		unjittered := backendDialBackoff(attempt, base, cap, false)
+		for i := 0; i < 50; i++ {
+			got := backendDialBackoff(attempt, base, cap, true)
+			if got < 0 || got > unjittered {
+				t.Fatalf("backendDialBackoff(%d, jitter=true) = %v, want in [0,%v]", attempt, got, unjittered)
+			}
+		}
+	}
+}
@@ -3,32 +3,68 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 
 	swtpmproxy "github.com/abrandao-census/swtpm-proxy-shim"
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s --type <vsock|tcp> --control-port <port> [--data-port <port>] [--control-retry-count <count>] <listen-socket> <host>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s --config <config.yaml>\n", os.Args[0])
 	os.Exit(1)
 }
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
 
 	var options swtpmproxy.SwtpmProxyOptions
 
+	configPath := flag.String("config", "", "Path to a multi-instance config file (mutually exclusive with the single-instance flags below)")
 	connType := flag.String("type", "", "Connection type: vsock or tcp")
 	controlPort := flag.Int("control-port", 0, "Control port number")
 	dataPort := flag.Int("data-port", 0, "Data port number (optional)")
 	controlRetryCount := flag.Int("control-retry-count", 10, "Control retry count (optional)")
+	tlsCA := flag.String("tls-ca", "", "PEM CA bundle used to verify the backend's TLS certificate (tcp backend only)")
+	tlsCert := flag.String("tls-cert", "", "Client certificate presented to the backend for mTLS (tcp backend only)")
+	tlsKey := flag.String("tls-key", "", "Private key for --tls-cert")
+	tlsServerName := flag.String("tls-servername", "", "Expected backend certificate name, overrides <host> (tcp backend only)")
+	requireTLS := flag.Bool("require-tls", false, "Refuse to start unless the backend connection is TLS-encrypted")
+	allowedControlCommands := flag.String("allowed-control-commands", "", "Comma-separated allow-list of control commands (e.g. CMD_INIT,CMD_GET_CAPABILITY); empty allows all")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics and /healthz, /readyz on (e.g. :9090); empty disables")
+	dialInitialBackoff := flag.Duration("backend-dial-initial-backoff", 2*time.Second, "Initial delay before retrying a failed backend dial, doubling on each attempt")
+	dialMaxBackoff := flag.Duration("backend-dial-max-backoff", 30*time.Second, "Maximum delay between backend dial retries")
+	dialJitter := flag.Bool("backend-dial-jitter", true, "Apply full jitter to backend dial retry delays")
 
 	flag.Usage = usage
 	flag.Parse()
 
+	if *configPath != "" {
+		var conflicting []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" && f.Name != "metrics-addr" {
+				conflicting = append(conflicting, "--"+f.Name)
+			}
+		})
+		if len(conflicting) > 0 || len(flag.Args()) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: --config is mutually exclusive with the single-instance flags and positional arguments\n")
+			usage()
+		}
+
+		runManager(*configPath, *metricsAddr)
+		return
+	}
+
 	switch *connType {
 	case "vsock":
 		options.BackendType = swtpmproxy.BackendVsock
@@ -63,25 +99,102 @@ func main() {
 	case swtpmproxy.BackendVsock:
 		cid, err := strconv.Atoi(args[1])
 		if err != nil {
-			log.Fatalf("Invalid CID: %v", err)
+			slog.Error("invalid CID", "error", err)
+			os.Exit(1)
 		}
 		options.BackendCid = uint32(cid)
 	case swtpmproxy.BackendIP:
 		options.BackendAddress = args[1]
 	}
 	options.BackendControlRetryCount = *controlRetryCount
+	options.BackendDialInitialBackoff = *dialInitialBackoff
+	options.BackendDialMaxBackoff = *dialMaxBackoff
+	options.BackendDialJitter = *dialJitter
+	options.RequireTLS = *requireTLS
+
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" || *tlsServerName != "" {
+		if options.BackendType != swtpmproxy.BackendIP {
+			fmt.Fprintln(os.Stderr, "Error: --tls-* flags are only valid with --type tcp")
+			usage()
+		}
+		options.BackendTLS = swtpmproxy.BackendTLSConfig{
+			Enabled:    true,
+			CAFile:     *tlsCA,
+			CertFile:   *tlsCert,
+			KeyFile:    *tlsKey,
+			ServerName: *tlsServerName,
+		}
+	}
+	if *requireTLS && !options.BackendTLS.Enabled {
+		fmt.Fprintln(os.Stderr, "Error: --require-tls requires --tls-ca, --tls-cert/--tls-key, or --tls-servername to enable TLS")
+		usage()
+	}
+	if *allowedControlCommands != "" {
+		options.AllowedControlCommands = strings.Split(*allowedControlCommands, ",")
+	}
 
-	fmt.Printf("Flags: type=%s, control-port=%d, data-port=%d, control-retry-count=%d\n", *connType, *controlPort, *dataPort, *controlRetryCount)
-	fmt.Printf("Control Socket Path: %s, Backend Address: %s Cid: %d\n", options.ControlSocketPath, options.BackendAddress, options.BackendCid)
-	startProxy(options)
+	slog.Info("starting proxy",
+		"type", *connType,
+		"control_port", *controlPort,
+		"data_port", *dataPort,
+		"control_retry_count", *controlRetryCount,
+		"control_socket", options.ControlSocketPath,
+		"backend_address", options.BackendAddress,
+		"backend_cid", options.BackendCid,
+	)
+	startProxy(options, *metricsAddr)
 }
 
-func startProxy(opts swtpmproxy.SwtpmProxyOptions) {
+func startProxy(opts swtpmproxy.SwtpmProxyOptions, metricsAddr string) {
 
 	proxy := swtpmproxy.NewSwtpmProxy(opts)
 
-	err := proxy.Start()
-	if err != nil {
-		log.Fatalf("Failed to start proxy: %v", err)
+	if metricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		proxy.Metrics = swtpmproxy.NewPrometheusMetrics(registry, proxy.Name())
+		if _, err := swtpmproxy.ServeObservability(metricsAddr, registry, []swtpmproxy.HealthChecker{proxy}); err != nil {
+			slog.Error("failed to start observability server", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	err := proxy.StartContext(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("failed to start proxy", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runManager starts the multi-tenant proxy manager from a config file and
+// blocks, reloading the configuration on SIGHUP and shutting down cleanly
+// on SIGTERM/SIGINT.
+func runManager(configPath, metricsAddr string) {
+	manager := swtpmproxy.NewManager(configPath)
+	manager.MetricsAddr = metricsAddr
+	if err := manager.Start(); err != nil {
+		slog.Error("failed to start proxy manager", "error", err)
+		os.Exit(1)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP:
+			slog.Info("received SIGHUP, reloading configuration")
+			if err := manager.Reload(); err != nil {
+				slog.Error("error reloading configuration", "error", err)
+			}
+		case syscall.SIGTERM, syscall.SIGINT:
+			slog.Info("shutting down proxy manager")
+			if err := manager.Stop(); err != nil {
+				slog.Error("error stopping proxy manager", "error", err)
+			}
+			return
+		}
 	}
 }